@@ -3,7 +3,9 @@ package vsphere
 import (
 	"fmt"
 	"log"
-        "time"
+	"net"
+	"path"
+	"time"
 
 	"github.com/hashicorp/terraform/helper/schema"
 	"github.com/vmware/govmomi"
@@ -11,6 +13,7 @@ import (
 	"github.com/vmware/govmomi/object"
 	"github.com/vmware/govmomi/property"
 	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
 	"golang.org/x/net/context"
 )
 
@@ -23,8 +26,11 @@ var DefaultDNSServers = []string{
 	"8.8.4.4",
 }
 
-func delaySecond(n time.Duration) {
-         time.Sleep(n * time.Second)
+const DefaultWaitForNetTimeout = 5
+
+func ipv4MaskString(prefixLength int) string {
+	mask := net.CIDRMask(prefixLength, 32)
+	return net.IPv4(mask[0], mask[1], mask[2], mask[3]).String()
 }
 
 func resourceVSphereVirtualMachine() *schema.Resource {
@@ -33,6 +39,9 @@ func resourceVSphereVirtualMachine() *schema.Resource {
 		Read:   resourceVSphereVirtualMachineRead,
 		Update: resourceVSphereVirtualMachineUpdate,
 		Delete: resourceVSphereVirtualMachineDelete,
+		Importer: &schema.ResourceImporter{
+			State: schema.ImportStatePassthrough,
+		},
 
 		Schema: map[string]*schema.Schema{
 			"name": &schema.Schema{
@@ -41,10 +50,24 @@ func resourceVSphereVirtualMachine() *schema.Resource {
 				ForceNew: true,
 			},
 
-                        "boot_delay": &schema.Schema{
+			"folder": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: true,
+			},
+
+			"auto_create_folder": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				ForceNew: true,
+				Default:  false,
+			},
+
+                        "wait_for_net_timeout": &schema.Schema{
                                 Type:     schema.TypeInt,
                                 Optional: true,
-                                ForceNew: true,
+                                Default:  DefaultWaitForNetTimeout,
+                                ForceNew: false,
                         },
 
 			"vcpu": &schema.Schema{
@@ -141,6 +164,44 @@ func resourceVSphereVirtualMachine() *schema.Resource {
                                                         Computed: true,
 						},
 
+						"ipv4_gateway": &schema.Schema{
+							Type:     schema.TypeString,
+							Optional: true,
+							ForceNew: false,
+						},
+
+						"ipv4_prefix_length": &schema.Schema{
+							Type:     schema.TypeInt,
+							Optional: true,
+							ForceNew: false,
+						},
+
+						"ipv6_address": &schema.Schema{
+							Type:     schema.TypeString,
+							Optional: true,
+							ForceNew: false,
+							Computed: true,
+						},
+
+						"ipv6_prefix_length": &schema.Schema{
+							Type:     schema.TypeInt,
+							Optional: true,
+							ForceNew: false,
+						},
+
+						"ipv6_gateway": &schema.Schema{
+							Type:     schema.TypeString,
+							Optional: true,
+							ForceNew: false,
+						},
+
+						"mac_address": &schema.Schema{
+							Type:     schema.TypeString,
+							Optional: true,
+							ForceNew: false,
+							Computed: true,
+						},
+
 						"adapter_type": &schema.Schema{
 							Type:     schema.TypeString,
 							Optional: true,
@@ -179,9 +240,116 @@ func resourceVSphereVirtualMachine() *schema.Resource {
 							Optional: true,
 							ForceNew: false,
 						},
+
+						"controller_type": &schema.Schema{
+							Type:     schema.TypeString,
+							Optional: true,
+							ForceNew: false,
+							Default:  "scsi",
+						},
+
+						"vmdk": &schema.Schema{
+							Type:     schema.TypeString,
+							Optional: true,
+							ForceNew: false,
+						},
+
+						"type": &schema.Schema{
+							Type:     schema.TypeString,
+							Optional: true,
+							ForceNew: false,
+							Default:  "thin",
+						},
+
+						"bootable": &schema.Schema{
+							Type:     schema.TypeBool,
+							Optional: true,
+							ForceNew: false,
+						},
+					},
+				},
+			},
+
+			"cdrom": &schema.Schema{
+				Type:     schema.TypeList,
+				Optional: true,
+				ForceNew: false,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"datastore": &schema.Schema{
+							Type:     schema.TypeString,
+							Required: true,
+							ForceNew: false,
+						},
+
+						"path": &schema.Schema{
+							Type:     schema.TypeString,
+							Required: true,
+							ForceNew: false,
+						},
+					},
+				},
+			},
+
+			"windows_opt_config": &schema.Schema{
+				Type:     schema.TypeList,
+				Optional: true,
+				MaxItems: 1,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"product_key": &schema.Schema{
+							Type:     schema.TypeString,
+							Optional: true,
+							ForceNew: false,
+						},
+
+						"admin_password": &schema.Schema{
+							Type:      schema.TypeString,
+							Optional:  true,
+							ForceNew:  false,
+							Sensitive: true,
+						},
+
+						"domain": &schema.Schema{
+							Type:     schema.TypeString,
+							Optional: true,
+							ForceNew: false,
+						},
+
+						"domain_user": &schema.Schema{
+							Type:     schema.TypeString,
+							Optional: true,
+							ForceNew: false,
+						},
+
+						"domain_user_password": &schema.Schema{
+							Type:      schema.TypeString,
+							Optional:  true,
+							ForceNew:  false,
+							Sensitive: true,
+						},
+
+						"workgroup": &schema.Schema{
+							Type:     schema.TypeString,
+							Optional: true,
+							ForceNew: false,
+						},
+
+						"time_zone": &schema.Schema{
+							Type:     schema.TypeInt,
+							Optional: true,
+							ForceNew: false,
+							Default:  85, // GMT Standard Time
+						},
 					},
 				},
 			},
+
+			"custom_configuration_parameters": &schema.Schema{
+				Type:     schema.TypeMap,
+				Optional: true,
+				ForceNew: false,
+			},
 		},
 	}
 }
@@ -195,6 +363,14 @@ func resourceVSphereVirtualMachineCreate(d *schema.ResourceData, meta interface{
 		memoryMb: int64(d.Get("memory").(int)),
 	}
 
+	if v, ok := d.GetOk("folder"); ok {
+		vm.folder = v.(string)
+	}
+
+	if v, ok := d.GetOk("auto_create_folder"); ok {
+		vm.autoCreateFolder = v.(bool)
+	}
+
 	if v, ok := d.GetOk("datacenter"); ok {
 		vm.datacenter = v.(string)
 	}
@@ -219,6 +395,22 @@ func resourceVSphereVirtualMachineCreate(d *schema.ResourceData, meta interface{
 		vm.timeZone = v.(string)
 	}
 
+	if _, ok := d.GetOk("windows_opt_config"); ok {
+		vm.windowsOptionalConfig = &windowsOptConfig{
+			productKey:         d.Get("windows_opt_config.0.product_key").(string),
+			adminPassword:      d.Get("windows_opt_config.0.admin_password").(string),
+			domain:             d.Get("windows_opt_config.0.domain").(string),
+			domainUser:         d.Get("windows_opt_config.0.domain_user").(string),
+			domainUserPassword: d.Get("windows_opt_config.0.domain_user_password").(string),
+			workgroup:          d.Get("windows_opt_config.0.workgroup").(string),
+			timeZone:           d.Get("windows_opt_config.0.time_zone").(int),
+		}
+
+		if vm.windowsOptionalConfig.workgroup != "" && vm.windowsOptionalConfig.domain != "" {
+			return fmt.Errorf("windows_opt_config: workgroup and domain are mutually exclusive")
+		}
+	}
+
 	dns_suffix := d.Get("dns_suffix.#").(int)
 	if dns_suffix > 0 {
 		vm.dnsSuffixes = make([]string, 0, dns_suffix)
@@ -250,6 +442,13 @@ func resourceVSphereVirtualMachineCreate(d *schema.ResourceData, meta interface{
 			networks[i].ipAddress = d.Get(prefix + ".ip_address").(string)
 			networks[i].subnetMask = d.Get(prefix + ".subnet_mask").(string)
 		}
+		networks[i].ipv4Gateway = d.Get(prefix + ".ipv4_gateway").(string)
+		networks[i].ipv4PrefixLength = d.Get(prefix + ".ipv4_prefix_length").(int)
+		networks[i].ipv6Address = d.Get(prefix + ".ipv6_address").(string)
+		networks[i].ipv6PrefixLength = d.Get(prefix + ".ipv6_prefix_length").(int)
+		networks[i].ipv6Gateway = d.Get(prefix + ".ipv6_gateway").(string)
+		networks[i].adapterType = d.Get(prefix + ".adapter_type").(string)
+		networks[i].macAddress = d.Get(prefix + ".mac_address").(string)
 	}
 	vm.networkInterfaces = networks
 	log.Printf("[DEBUG] network_interface init: %v", networks)
@@ -258,24 +457,32 @@ func resourceVSphereVirtualMachineCreate(d *schema.ResourceData, meta interface{
 	disks := make([]hardDisk, diskCount)
 	for i := 0; i < diskCount; i++ {
 		prefix := fmt.Sprintf("disk.%d", i)
+
+		disks[i].controllerType = d.Get(prefix + ".controller_type").(string)
+		disks[i].diskType = d.Get(prefix + ".type").(string)
+		disks[i].bootable = d.Get(prefix + ".bootable").(bool)
+		if v := d.Get(prefix + ".vmdk"); v != "" {
+			disks[i].vmdkPath = d.Get(prefix + ".vmdk").(string)
+		}
+
 		if i == 0 {
 			if v := d.Get(prefix + ".template"); v != "" {
 				vm.template = d.Get(prefix + ".template").(string)
-			} else {
+			} else if disks[i].vmdkPath == "" {
 				if v := d.Get(prefix + ".size"); v != "" {
 					disks[i].size = int64(d.Get(prefix + ".size").(int))
 				} else {
-					return fmt.Errorf("If template argument is not specified, size argument is required.")
+					return fmt.Errorf("If template argument is not specified, size or vmdk argument is required.")
 				}
 			}
 			if v := d.Get(prefix + ".datastore"); v != "" {
 				vm.datastore = d.Get(prefix + ".datastore").(string)
 			}
-		} else {
+		} else if disks[i].vmdkPath == "" {
 			if v := d.Get(prefix + ".size"); v != "" {
 				disks[i].size = int64(d.Get(prefix + ".size").(int))
 			} else {
-				return fmt.Errorf("Size argument is required.")
+				return fmt.Errorf("Size or vmdk argument is required.")
 			}
 		}
 		if v := d.Get(prefix + ".iops"); v != "" {
@@ -285,6 +492,22 @@ func resourceVSphereVirtualMachineCreate(d *schema.ResourceData, meta interface{
 	vm.hardDisks = disks
 	log.Printf("[DEBUG] disk init: %v", disks)
 
+	cdromCount := d.Get("cdrom.#").(int)
+	cdroms := make([]cdrom, cdromCount)
+	for i := 0; i < cdromCount; i++ {
+		prefix := fmt.Sprintf("cdrom.%d", i)
+		cdroms[i].datastore = d.Get(prefix + ".datastore").(string)
+		cdroms[i].path = d.Get(prefix + ".path").(string)
+	}
+	vm.cdroms = cdroms
+
+	if raw, ok := d.GetOk("custom_configuration_parameters"); ok {
+		vm.customConfigurationParameters = make(map[string]string)
+		for k, v := range raw.(map[string]interface{}) {
+			vm.customConfigurationParameters[k] = v.(string)
+		}
+	}
+
 	if vm.template != "" {
 		err := vm.deployVirtualMachine(client)
 		if err != nil {
@@ -296,7 +519,7 @@ func resourceVSphereVirtualMachineCreate(d *schema.ResourceData, meta interface{
 			return fmt.Errorf("error: %s", err)
 		}
 	}
-	d.SetId(vm.name)
+	d.SetId(vm.path())
 	log.Printf("[INFO] Created virtual machine: %s", d.Id())
 
 	return resourceVSphereVirtualMachineRead(d, meta)
@@ -322,94 +545,518 @@ func resourceVSphereVirtualMachineRead(d *schema.ResourceData, meta interface{})
 	}
 
 	finder = finder.SetDatacenter(dc)
-	vm, err := finder.VirtualMachine(context.TODO(), d.Get("name").(string))
+	vm, err := finder.VirtualMachine(context.TODO(), d.Id())
 	if err != nil {
-		log.Printf("[ERROR] Virtual machine not found: %s", d.Get("name").(string))
+		log.Printf("[ERROR] Virtual machine not found: %s", d.Id())
 		d.SetId("")
 		return nil
 	}
 
+	d.Set("name", path.Base(d.Id()))
+	if folder := path.Dir(d.Id()); folder != "." {
+		d.Set("folder", folder)
+	}
+
 	var mvm mo.VirtualMachine
 
 	collector := property.DefaultCollector(client.Client)
 	err = collector.RetrieveOne(context.TODO(), vm.Reference(), []string{"summary"}, &mvm)
+	if err != nil {
+		return err
+	}
 
 	d.Set("datacenter", dc)
 	d.Set("memory", mvm.Summary.Config.MemorySizeMB)
 	d.Set("cpu", mvm.Summary.Config.NumCpu)
 
-        var ip_address string
-
-        if d.Get("network_interface.0.ip_address") != "" {
-            log.Printf("[DEBUG] DHCP is NOT set on the first interface")
-            ip_address = d.Get("network_interface.0.ip_address").(string)
-            log.Printf("[DEBUG] static ip of the first interface is %s", ip_address)
-        } else {
-            log.Printf("[DEBUG] DHCP is set on the first interface")
-            BootTime := *mvm.Summary.Runtime.BootTime
-            log.Printf("[DEBUG] vm booted at %v", BootTime)
-            duration := time.Since(BootTime)
-            log.Printf("[DEBUG] it has been %f", duration.Seconds())
-            log.Printf("[DEBUG] configured boot_delay delay is %v", d.Get("boot_delay").(int))
-            remaining_boot_delay := float64(d.Get("boot_delay").(int)) - float64(duration.Seconds())
-            log.Printf("[DEBUG] remaining time to wait %f", remaining_boot_delay)
-            if remaining_boot_delay > 0 {
-                log.Printf("[DEBUG] boot delay has been enabled, waiting another %v", int(remaining_boot_delay))
-                delaySecond( time.Duration(int(remaining_boot_delay)) )
-                //reconnect to refresh ip
-                collector := property.DefaultCollector(client.Client)
-                err = collector.RetrieveOne(context.TODO(), vm.Reference(), []string{"summary"}, &mvm)
-                ip_address = mvm.Summary.Guest.IpAddress
-                //sometimes boot_delay is too short and you get an empty ip address
-                for ip_address == "" {
-                    log.Printf("[DEBUG] problem getting ip address, retrying")
-                    collector := property.DefaultCollector(client.Client)
-                    err = collector.RetrieveOne(context.TODO(), vm.Reference(), []string{"summary"}, &mvm)
-                    ip_address = mvm.Summary.Guest.IpAddress
-                    delaySecond( time.Duration(1) )
-                }
-            } else {
-                log.Printf("[DEBUG] boot delay time has passed")
-            }
-            ip_address = mvm.Summary.Guest.IpAddress
-        }
-
-        log.Printf("[DEBUG] static ip of the first interface is %s", ip_address)
-
-        //set connection info
-        d.Set("ip_address", ip_address)
-        d.SetConnInfo(map[string]string{
-            "host": ip_address,
-        })
+	netInfo := make(map[string][]string)
+	if !allNetworkInterfacesStatic(d) {
+		timeout := time.Duration(d.Get("wait_for_net_timeout").(int)) * time.Minute
+		log.Printf("[DEBUG] waiting up to %v for an IP address", timeout)
+
+		waitCtx, cancel := context.WithTimeout(context.TODO(), timeout)
+		defer cancel()
+
+		netInfo, err = vm.WaitForNetIP(waitCtx, false)
+		if err != nil {
+			return fmt.Errorf("timeout waiting for an IP address: %s", err)
+		}
+	}
+
+	err = collector.RetrieveOne(context.TODO(), vm.Reference(), []string{"guest.net"}, &mvm)
+	if err != nil {
+		return err
+	}
+
+	var ip_address string
+	for i, nic := range mvm.Guest.Net {
+		prefix := fmt.Sprintf("network_interface.%d", i)
+		d.Set(prefix+".mac_address", nic.MacAddress)
+
+		ips, ok := netInfo[nic.MacAddress]
+		if !ok || len(ips) == 0 {
+			continue
+		}
+
+		var ipv4Addr, ipv6Addr string
+		for _, ip := range ips {
+			parsed := net.ParseIP(ip)
+			if parsed == nil {
+				continue
+			}
+			if parsed.To4() != nil {
+				if ipv4Addr == "" {
+					ipv4Addr = ip
+				}
+			} else if ipv6Addr == "" {
+				ipv6Addr = ip
+			}
+		}
+
+		if ipv4Addr != "" {
+			d.Set(prefix+".ip_address", ipv4Addr)
+			if i == 0 {
+				ip_address = ipv4Addr
+			}
+		}
+		if ipv6Addr != "" {
+			d.Set(prefix+".ipv6_address", ipv6Addr)
+		}
+
+		if nic.IpConfig != nil {
+			for _, ipConfig := range nic.IpConfig.IpAddress {
+				switch ipConfig.IpAddress {
+				case ipv4Addr:
+					d.Set(prefix+".subnet_mask", ipv4MaskString(int(ipConfig.PrefixLength)))
+				case ipv6Addr:
+					d.Set(prefix+".ipv6_prefix_length", int(ipConfig.PrefixLength))
+				}
+			}
+		}
+	}
+
+	if ip_address == "" {
+		ip_address = d.Get("network_interface.0.ip_address").(string)
+	}
+
+	log.Printf("[DEBUG] ip address of the first interface is %s", ip_address)
+
+	//set connection info
+	d.Set("ip_address", ip_address)
+	d.SetConnInfo(map[string]string{
+		"host": ip_address,
+	})
 
 	return nil
 }
 
-func resourceVSphereVirtualMachineUpdate(d *schema.ResourceData, meta interface{}) error {
-	return nil
+func allNetworkInterfacesStatic(d *schema.ResourceData) bool {
+	count := d.Get("network_interface.#").(int)
+	if count == 0 {
+		return false
+	}
+
+	for i := 0; i < count; i++ {
+		prefix := fmt.Sprintf("network_interface.%d", i)
+		if d.Get(prefix+".ip_address").(string) == "" || d.Get(prefix+".subnet_mask").(string) == "" {
+			return false
+		}
+	}
+
+	return true
 }
 
-func resourceVSphereVirtualMachineDelete(d *schema.ResourceData, meta interface{}) error {
+func findVirtualMachine(client *govmomi.Client, d *schema.ResourceData) (*object.VirtualMachine, error) {
 	var dc *object.Datacenter
 	var err error
 
-	client := meta.(*govmomi.Client)
 	finder := find.NewFinder(client.Client, true)
 
 	if v, ok := d.GetOk("datacenter"); ok {
 		dc, err = finder.Datacenter(context.TODO(), v.(string))
+	} else {
+		dc, err = finder.DefaultDatacenter(context.TODO())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	finder = finder.SetDatacenter(dc)
+	return finder.VirtualMachine(context.TODO(), d.Id())
+}
+
+func resourceVSphereVirtualMachineUpdate(d *schema.ResourceData, meta interface{}) error {
+	client := meta.(*govmomi.Client)
+
+	vm, err := findVirtualMachine(client, d)
+	if err != nil {
+		return err
+	}
+
+	devices, err := vm.Device(context.TODO())
+	if err != nil {
+		return err
+	}
+
+	spec := types.VirtualMachineConfigSpec{}
+	hotAddRequired := false
+
+	if d.HasChange("vcpu") {
+		spec.NumCPUs = d.Get("vcpu").(int)
+		hotAddRequired = true
+	}
+
+	if d.HasChange("memory") {
+		spec.MemoryMB = int64(d.Get("memory").(int))
+		hotAddRequired = true
+	}
+
+	if d.HasChange("disk") {
+		diskChanges, bootDiskKey, err := diskDeviceChanges(d, devices)
 		if err != nil {
 			return err
 		}
-	} else {
-		dc, err = finder.DefaultDatacenter(context.TODO())
+		spec.DeviceChange = append(spec.DeviceChange, diskChanges...)
+		if bootDiskKey != 0 {
+			spec.BootOptions = &types.VirtualMachineBootOptions{
+				BootOrder: []types.BaseVirtualMachineBootOptionsBootableDevice{
+					&types.VirtualMachineBootOptionsBootableDiskDevice{DeviceKey: bootDiskKey},
+				},
+			}
+		}
+	}
+
+	if d.HasChange("network_interface") {
+		networkChanges, err := networkDeviceChanges(client, d, devices)
 		if err != nil {
 			return err
 		}
+		spec.DeviceChange = append(spec.DeviceChange, networkChanges...)
 	}
 
-	finder = finder.SetDatacenter(dc)
-	vm, err := finder.VirtualMachine(context.TODO(), d.Get("name").(string))
+	if d.HasChange("cdrom") {
+		cdromChanges, err := cdromDeviceChanges(d, devices)
+		if err != nil {
+			return err
+		}
+		spec.DeviceChange = append(spec.DeviceChange, cdromChanges...)
+	}
+
+	if d.HasChange("custom_configuration_parameters") {
+		oldParams, newParams := d.GetChange("custom_configuration_parameters")
+
+		params := make(map[string]string)
+		for k, v := range newParams.(map[string]interface{}) {
+			params[k] = v.(string)
+		}
+
+		for k := range oldParams.(map[string]interface{}) {
+			if _, ok := params[k]; !ok {
+				params[k] = ""
+			}
+		}
+
+		spec.ExtraConfig = extraConfig(params)
+	}
+
+	if spec.NumCPUs == 0 && spec.MemoryMB == 0 && len(spec.DeviceChange) == 0 && len(spec.ExtraConfig) == 0 {
+		return resourceVSphereVirtualMachineRead(d, meta)
+	}
+
+	poweredOff := false
+	if hotAddRequired {
+		supportsHotAdd, err := vmSupportsHotAdd(client, vm)
+		if err != nil {
+			return err
+		}
+		if !supportsHotAdd {
+			log.Printf("[DEBUG] guest does not support CPU/memory hot-add, powering off to reconfigure: %s", d.Id())
+			task, err := vm.PowerOff(context.TODO())
+			if err != nil {
+				return err
+			}
+			if err := task.Wait(context.TODO()); err != nil {
+				return err
+			}
+			poweredOff = true
+		}
+	}
+
+	task, err := vm.Reconfigure(context.TODO(), spec)
+	if err != nil {
+		return err
+	}
+
+	if err := task.Wait(context.TODO()); err != nil {
+		return err
+	}
+
+	if poweredOff {
+		task, err := vm.PowerOn(context.TODO())
+		if err != nil {
+			return err
+		}
+		if err := task.Wait(context.TODO()); err != nil {
+			return err
+		}
+	}
+
+	return resourceVSphereVirtualMachineRead(d, meta)
+}
+
+func vmSupportsHotAdd(client *govmomi.Client, vm *object.VirtualMachine) (bool, error) {
+	var mvm mo.VirtualMachine
+
+	collector := property.DefaultCollector(client.Client)
+	if err := collector.RetrieveOne(context.TODO(), vm.Reference(), []string{"config"}, &mvm); err != nil {
+		return false, err
+	}
+
+	return mvm.Config.CpuHotAddEnabled != nil && *mvm.Config.CpuHotAddEnabled &&
+		mvm.Config.MemoryHotAddEnabled != nil && *mvm.Config.MemoryHotAddEnabled, nil
+}
+
+func controllerOfKind(devices *object.VirtualDeviceList, changes *[]types.BaseVirtualDeviceConfigSpec, controllerType string) (types.BaseVirtualController, error) {
+	if controllerType == "" {
+		controllerType = "scsi"
+	}
+
+	if controllerType == "ide" {
+		if c, err := devices.FindIDEController(""); err == nil {
+			return c, nil
+		}
+		device, err := devices.CreateIDEController()
+		if err != nil {
+			return nil, fmt.Errorf("error creating IDE controller: %s", err)
+		}
+		*devices = append(*devices, device)
+		*changes = append(*changes, &types.VirtualDeviceConfigSpec{
+			Device:    device,
+			Operation: types.VirtualDeviceConfigSpecOperationAdd,
+		})
+		return device.(types.BaseVirtualController), nil
+	}
+
+	kind := scsiControllerKind(controllerType)
+	if c, err := devices.FindSCSIController(kind); err == nil {
+		return c, nil
+	}
+	device, err := devices.CreateSCSIController(kind)
+	if err != nil {
+		return nil, fmt.Errorf("error creating %s controller: %s", controllerType, err)
+	}
+	*devices = append(*devices, device)
+	*changes = append(*changes, &types.VirtualDeviceConfigSpec{
+		Device:    device,
+		Operation: types.VirtualDeviceConfigSpecOperationAdd,
+	})
+	return device.(types.BaseVirtualController), nil
+}
+
+func diskDeviceChanges(d *schema.ResourceData, devices object.VirtualDeviceList) ([]types.BaseVirtualDeviceConfigSpec, int32, error) {
+	existingDisks := devices.SelectByType((*types.VirtualDisk)(nil))
+
+	var changes []types.BaseVirtualDeviceConfigSpec
+	var bootDiskKey int32
+
+	diskCount := d.Get("disk.#").(int)
+	for i := 0; i < diskCount; i++ {
+		prefix := fmt.Sprintf("disk.%d", i)
+		sizeKB := int64(d.Get(prefix+".size").(int)) * 1024 * 1024
+		iops := int64(d.Get(prefix + ".iops").(int))
+		bootable := d.Get(prefix + ".bootable").(bool)
+
+		if i < len(existingDisks) {
+			disk := existingDisks[i].(*types.VirtualDisk)
+			if bootable {
+				bootDiskKey = disk.Key
+			}
+
+			if sizeKB <= disk.CapacityInKB && iops == 0 {
+				continue
+			}
+
+			if sizeKB > disk.CapacityInKB {
+				disk.CapacityInKB = sizeKB
+			}
+			if iops > 0 {
+				disk.StorageIOAllocation = &types.StorageIOAllocationInfo{Limit: &iops}
+			}
+
+			changes = append(changes, &types.VirtualDeviceConfigSpec{
+				Device:    disk,
+				Operation: types.VirtualDeviceConfigSpecOperationEdit,
+			})
+			continue
+		}
+
+		controllerType := d.Get(prefix + ".controller_type").(string)
+		controller, err := controllerOfKind(&devices, &changes, controllerType)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		vmdkPath := d.Get(prefix + ".vmdk").(string)
+
+		var datastorePath object.DatastorePath
+		if vmdkPath != "" {
+			datastorePath.Path = vmdkPath
+		}
+
+		disk := devices.CreateDisk(controller, datastorePath)
+		if backing, ok := disk.Backing.(*types.VirtualDiskFlatVer2BackingInfo); ok {
+			applyDiskProvisioning(backing, d.Get(prefix+".type").(string))
+		}
+
+		change := &types.VirtualDeviceConfigSpec{
+			Device:    disk,
+			Operation: types.VirtualDeviceConfigSpecOperationAdd,
+		}
+
+		if vmdkPath == "" {
+			disk.CapacityInKB = sizeKB
+			if iops > 0 {
+				disk.StorageIOAllocation = &types.StorageIOAllocationInfo{Limit: &iops}
+			}
+			change.FileOperation = types.VirtualDeviceConfigSpecFileOperationCreate
+		}
+
+		if bootable {
+			bootDiskKey = disk.Key
+		}
+
+		changes = append(changes, change)
+	}
+
+	return changes, bootDiskKey, nil
+}
+
+func networkDeviceChanges(client *govmomi.Client, d *schema.ResourceData, devices object.VirtualDeviceList) ([]types.BaseVirtualDeviceConfigSpec, error) {
+	existingNics := devices.SelectByType((*types.VirtualEthernetCard)(nil))
+
+	var changes []types.BaseVirtualDeviceConfigSpec
+
+	desired := d.Get("network_interface.#").(int)
+
+	if desired > len(existingNics) {
+		finder := find.NewFinder(client.Client, true)
+		if v, ok := d.GetOk("datacenter"); ok {
+			dc, err := finder.Datacenter(context.TODO(), v.(string))
+			if err != nil {
+				return nil, err
+			}
+			finder = finder.SetDatacenter(dc)
+		}
+
+		for i := len(existingNics); i < desired; i++ {
+			prefix := fmt.Sprintf("network_interface.%d", i)
+			label := d.Get(prefix + ".label").(string)
+
+			network, err := finder.Network(context.TODO(), label)
+			if err != nil {
+				return nil, err
+			}
+
+			backing, err := network.EthernetCardBackingInfo(context.TODO())
+			if err != nil {
+				return nil, err
+			}
+
+			adapterType := d.Get(prefix + ".adapter_type").(string)
+			if adapterType == "" {
+				adapterType = "e1000"
+			}
+
+			nic, err := object.EthernetCardTypes().CreateEthernetCard(adapterType, backing)
+			if err != nil {
+				return nil, err
+			}
+
+			if macAddress := d.Get(prefix + ".mac_address").(string); macAddress != "" {
+				card := nic.(types.BaseVirtualEthernetCard).GetVirtualEthernetCard()
+				card.AddressType = string(types.VirtualEthernetCardMacTypeManual)
+				card.MacAddress = macAddress
+			}
+
+			changes = append(changes, &types.VirtualDeviceConfigSpec{
+				Device:    nic,
+				Operation: types.VirtualDeviceConfigSpecOperationAdd,
+			})
+		}
+	}
+
+	for i := desired; i < len(existingNics); i++ {
+		changes = append(changes, &types.VirtualDeviceConfigSpec{
+			Device:    existingNics[i],
+			Operation: types.VirtualDeviceConfigSpecOperationRemove,
+		})
+	}
+
+	return changes, nil
+}
+
+func cdromDeviceChanges(d *schema.ResourceData, devices object.VirtualDeviceList) ([]types.BaseVirtualDeviceConfigSpec, error) {
+	existingCdroms := devices.SelectByType((*types.VirtualCdrom)(nil))
+
+	var changes []types.BaseVirtualDeviceConfigSpec
+
+	desired := d.Get("cdrom.#").(int)
+	for i := 0; i < desired; i++ {
+		prefix := fmt.Sprintf("cdrom.%d", i)
+		iso := fmt.Sprintf("[%s] %s", d.Get(prefix+".datastore").(string), d.Get(prefix+".path").(string))
+
+		if i < len(existingCdroms) {
+			device := existingCdroms[i]
+			if backing, ok := device.GetVirtualDevice().Backing.(*types.VirtualCdromIsoBackingInfo); ok && backing.FileName == iso {
+				continue
+			}
+
+			changes = append(changes, &types.VirtualDeviceConfigSpec{
+				Device:    devices.InsertIso(device, iso),
+				Operation: types.VirtualDeviceConfigSpecOperationEdit,
+			})
+			continue
+		}
+
+		controller, err := devices.FindIDEController("")
+		if err != nil {
+			device, cerr := devices.CreateIDEController()
+			if cerr != nil {
+				return nil, fmt.Errorf("error creating IDE controller for cdrom: %s", cerr)
+			}
+			devices = append(devices, device)
+			changes = append(changes, &types.VirtualDeviceConfigSpec{
+				Device:    device,
+				Operation: types.VirtualDeviceConfigSpecOperationAdd,
+			})
+			controller = device.(*types.VirtualIDEController)
+		}
+
+		cdrom, err := devices.CreateCdrom(controller)
+		if err != nil {
+			return nil, fmt.Errorf("error creating cdrom device: %s", err)
+		}
+
+		changes = append(changes, &types.VirtualDeviceConfigSpec{
+			Device:    devices.InsertIso(cdrom, iso),
+			Operation: types.VirtualDeviceConfigSpecOperationAdd,
+		})
+	}
+
+	for i := desired; i < len(existingCdroms); i++ {
+		changes = append(changes, &types.VirtualDeviceConfigSpec{
+			Device:    existingCdroms[i],
+			Operation: types.VirtualDeviceConfigSpecOperationRemove,
+		})
+	}
+
+	return changes, nil
+}
+
+func resourceVSphereVirtualMachineDelete(d *schema.ResourceData, meta interface{}) error {
+	client := meta.(*govmomi.Client)
+
+	vm, err := findVirtualMachine(client, d)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,599 @@
+package vsphere
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+	"golang.org/x/net/context"
+)
+
+type networkInterface struct {
+	label            string
+	ipAddress        string
+	subnetMask       string
+	ipv4Gateway      string
+	ipv4PrefixLength int
+	ipv6Address      string
+	ipv6PrefixLength int
+	ipv6Gateway      string
+	adapterType      string
+	macAddress       string
+}
+
+type hardDisk struct {
+	size           int64
+	iops           int64
+	controllerType string
+	vmdkPath       string
+	diskType       string
+	bootable       bool
+}
+
+type cdrom struct {
+	datastore string
+	path      string
+}
+
+type windowsOptConfig struct {
+	productKey         string
+	adminPassword      string
+	domain             string
+	domainUser         string
+	domainUserPassword string
+	workgroup          string
+	timeZone           int
+}
+
+type virtualMachine struct {
+	name                           string
+	folder                         string
+	autoCreateFolder               bool
+	datacenter                     string
+	cluster                        string
+	resourcePool                   string
+	datastore                      string
+	vcpu                           int
+	memoryMb                       int64
+	template                       string
+	gateway                        string
+	domain                         string
+	timeZone                       string
+	dnsSuffixes                    []string
+	dnsServers                     []string
+	networkInterfaces              []networkInterface
+	hardDisks                      []hardDisk
+	cdroms                         []cdrom
+	windowsOptionalConfig          *windowsOptConfig
+	customConfigurationParameters  map[string]string
+}
+
+func (vm *virtualMachine) path() string {
+	if vm.folder == "" {
+		return vm.name
+	}
+	return path.Join(vm.folder, vm.name)
+}
+
+func getDatacenter(c *govmomi.Client, dc string) (*object.Datacenter, error) {
+	finder := find.NewFinder(c.Client, true)
+	if dc != "" {
+		return finder.Datacenter(context.TODO(), dc)
+	}
+	return finder.DefaultDatacenter(context.TODO())
+}
+
+func (vm *virtualMachine) getResourcePool(finder *find.Finder) (*object.ResourcePool, error) {
+	switch {
+	case vm.resourcePool != "":
+		return finder.ResourcePool(context.TODO(), vm.resourcePool)
+	case vm.cluster != "":
+		return finder.ResourcePool(context.TODO(), fmt.Sprintf("*%s/Resources", vm.cluster))
+	default:
+		return finder.DefaultResourcePool(context.TODO())
+	}
+}
+
+func (vm *virtualMachine) getDatastore(finder *find.Finder) (*object.Datastore, error) {
+	if vm.datastore != "" {
+		return finder.Datastore(context.TODO(), vm.datastore)
+	}
+	return finder.DefaultDatastore(context.TODO())
+}
+
+func (vm *virtualMachine) resolveVMFolder(c *govmomi.Client, dc *object.Datacenter) (*object.Folder, error) {
+	dcFolders, err := dc.Folders(context.TODO())
+	if err != nil {
+		return nil, err
+	}
+
+	if vm.folder == "" {
+		return dcFolders.VmFolder, nil
+	}
+
+	finder := find.NewFinder(c.Client, true).SetDatacenter(dc)
+
+	folder, err := finder.Folder(context.TODO(), vm.folder)
+	if err == nil {
+		return folder, nil
+	}
+
+	if !vm.autoCreateFolder {
+		return nil, fmt.Errorf("folder %q does not exist (set auto_create_folder to create it): %s", vm.folder, err)
+	}
+
+	current := dcFolders.VmFolder
+	currentPath := ""
+	for _, name := range strings.Split(vm.folder, "/") {
+		if name == "" {
+			continue
+		}
+		currentPath = path.Join(currentPath, name)
+
+		if next, ferr := finder.Folder(context.TODO(), currentPath); ferr == nil {
+			current = next
+			continue
+		}
+
+		current, err = current.CreateFolder(context.TODO(), name)
+		if err != nil {
+			return nil, fmt.Errorf("error creating folder %q: %s", currentPath, err)
+		}
+	}
+
+	return current, nil
+}
+
+func (vm *virtualMachine) customizationIPSettings(network networkInterface) types.CustomizationIPSettings {
+	settings := types.CustomizationIPSettings{}
+
+	if network.ipAddress == "" {
+		settings.Ip = &types.CustomizationDhcpIpGenerator{}
+	} else {
+		settings.Ip = &types.CustomizationFixedIp{IpAddress: network.ipAddress}
+		settings.SubnetMask = network.subnetMask
+		if settings.SubnetMask == "" && network.ipv4PrefixLength > 0 {
+			settings.SubnetMask = ipv4MaskString(network.ipv4PrefixLength)
+		}
+
+		gateway := vm.gateway
+		if network.ipv4Gateway != "" {
+			gateway = network.ipv4Gateway
+		}
+		if gateway != "" {
+			settings.Gateway = []string{gateway}
+		}
+	}
+
+	if network.ipv6Address != "" {
+		ipv6Spec := &types.CustomizationIPSettingsIpV6AddressSpec{
+			Ip: []types.BaseCustomizationIpV6Generator{
+				&types.CustomizationFixedIpV6{
+					IpAddress:  network.ipv6Address,
+					SubnetMask: int32(network.ipv6PrefixLength),
+				},
+			},
+		}
+		if network.ipv6Gateway != "" {
+			ipv6Spec.Gateway = []string{network.ipv6Gateway}
+		}
+		settings.IpV6Spec = ipv6Spec
+	}
+
+	return settings
+}
+
+func isWindowsGuestID(guestID string) bool {
+	return strings.HasPrefix(strings.ToLower(guestID), "win")
+}
+
+func (vm *virtualMachine) customizationIdentity(guestID string) (types.BaseCustomizationIdentitySettings, error) {
+	if !isWindowsGuestID(guestID) {
+		return &types.CustomizationLinuxPrep{
+			HostName: &types.CustomizationFixedName{Name: vm.name},
+			Domain:   vm.domain,
+		}, nil
+	}
+
+	w := vm.windowsOptionalConfig
+	if w == nil {
+		return nil, fmt.Errorf("windows_opt_config is required to customize Windows template %q", vm.template)
+	}
+	if w.workgroup != "" && w.domain != "" {
+		return nil, fmt.Errorf("windows_opt_config: workgroup and domain are mutually exclusive")
+	}
+
+	guiUnattended := types.CustomizationGuiUnattended{
+		AutoLogon:      w.adminPassword != "",
+		AutoLogonCount: 1,
+		TimeZone:       int32(w.timeZone),
+	}
+	if w.adminPassword != "" {
+		guiUnattended.Password = &types.CustomizationPassword{
+			PlainText: true,
+			Value:     w.adminPassword,
+		}
+	}
+
+	identification := types.CustomizationIdentification{}
+	if w.domain != "" {
+		identification.JoinDomain = w.domain
+		identification.DomainAdmin = w.domainUser
+		if w.domainUserPassword != "" {
+			identification.DomainAdminPassword = &types.CustomizationPassword{
+				PlainText: true,
+				Value:     w.domainUserPassword,
+			}
+		}
+	} else {
+		identification.JoinWorkgroup = w.workgroup
+	}
+
+	return &types.CustomizationSysprep{
+		GuiUnattended:  guiUnattended,
+		Identification: identification,
+		UserData: types.CustomizationUserData{
+			ComputerName: &types.CustomizationFixedName{Name: vm.name},
+			FullName:     "terraform",
+			OrgName:      "terraform",
+			ProductId:    w.productKey,
+		},
+	}, nil
+}
+
+func (vm *virtualMachine) customizationSpec(guestID string) (types.CustomizationSpec, error) {
+	adapterMaps := make([]types.CustomizationAdapterMapping, 0, len(vm.networkInterfaces))
+	for _, network := range vm.networkInterfaces {
+		adapterMaps = append(adapterMaps, types.CustomizationAdapterMapping{
+			Adapter: vm.customizationIPSettings(network),
+		})
+	}
+
+	identity, err := vm.customizationIdentity(guestID)
+	if err != nil {
+		return types.CustomizationSpec{}, err
+	}
+
+	return types.CustomizationSpec{
+		Identity: identity,
+		GlobalIPSettings: types.CustomizationGlobalIPSettings{
+			DnsSuffixList: vm.dnsSuffixes,
+			DnsServerList: vm.dnsServers,
+		},
+		NicSettingMap: adapterMaps,
+	}, nil
+}
+
+func (vm *virtualMachine) deployVirtualMachine(c *govmomi.Client) error {
+	dc, err := getDatacenter(c, vm.datacenter)
+	if err != nil {
+		return err
+	}
+
+	finder := find.NewFinder(c.Client, true).SetDatacenter(dc)
+
+	template, err := finder.VirtualMachine(context.TODO(), vm.template)
+	if err != nil {
+		return err
+	}
+
+	resourcePool, err := vm.getResourcePool(finder)
+	if err != nil {
+		return err
+	}
+
+	datastore, err := vm.getDatastore(finder)
+	if err != nil {
+		return err
+	}
+
+	folder, err := vm.resolveVMFolder(c, dc)
+	if err != nil {
+		return err
+	}
+
+	poolRef := resourcePool.Reference()
+	datastoreRef := datastore.Reference()
+	folderRef := folder.Reference()
+
+	customization, err := vm.customizationSpecPtr(c, template)
+	if err != nil {
+		return err
+	}
+
+	devices := object.VirtualDeviceList{}
+	if err := addCdromDevices(&devices, map[string]types.BaseVirtualController{}, vm.cdroms); err != nil {
+		return err
+	}
+
+	var deviceChange []types.BaseVirtualDeviceConfigSpec
+	if len(devices) > 0 {
+		deviceChange, err = devices.ConfigSpec(types.VirtualDeviceConfigSpecOperationAdd)
+		if err != nil {
+			return err
+		}
+	}
+
+	cloneSpec := types.VirtualMachineCloneSpec{
+		Location: types.VirtualMachineRelocateSpec{
+			Pool:      &poolRef,
+			Datastore: &datastoreRef,
+			Folder:    &folderRef,
+		},
+		Template:      false,
+		PowerOn:       true,
+		Customization: customization,
+		Config: &types.VirtualMachineConfigSpec{
+			NumCPUs:      vm.vcpu,
+			MemoryMB:     vm.memoryMb,
+			DeviceChange: deviceChange,
+			ExtraConfig:  extraConfig(vm.customConfigurationParameters),
+		},
+	}
+
+	task, err := template.Clone(context.TODO(), folder, vm.name, cloneSpec)
+	if err != nil {
+		return err
+	}
+
+	return task.Wait(context.TODO())
+}
+
+func (vm *virtualMachine) customizationSpecPtr(c *govmomi.Client, template *object.VirtualMachine) (*types.CustomizationSpec, error) {
+	if len(vm.networkInterfaces) == 0 {
+		return nil, nil
+	}
+
+	var mvm mo.VirtualMachine
+	collector := property.DefaultCollector(c.Client)
+	if err := collector.RetrieveOne(context.TODO(), template.Reference(), []string{"config.guestId"}, &mvm); err != nil {
+		return nil, err
+	}
+
+	spec, err := vm.customizationSpec(mvm.Config.GuestId)
+	if err != nil {
+		return nil, err
+	}
+	return &spec, nil
+}
+
+func scsiControllerKind(controllerType string) string {
+	switch controllerType {
+	case "scsi-buslogic":
+		return "buslogic"
+	case "scsi-paravirtual":
+		return "pvscsi"
+	case "scsi-lsi-sas":
+		return "lsilogic-sas"
+	default: // "scsi", "scsi-lsi-parallel"
+		return "lsilogic"
+	}
+}
+
+func applyDiskProvisioning(backing *types.VirtualDiskFlatVer2BackingInfo, diskType string) {
+	thin := diskType == "" || diskType == "thin"
+	eager := diskType == "eager_zeroed"
+
+	backing.ThinProvisioned = &thin
+	backing.EagerlyScrub = &eager
+}
+
+func extraConfig(params map[string]string) []types.BaseOptionValue {
+	if len(params) == 0 {
+		return nil
+	}
+
+	options := make([]types.BaseOptionValue, 0, len(params))
+	for k, v := range params {
+		options = append(options, &types.OptionValue{Key: k, Value: v})
+	}
+	return options
+}
+
+func addControllerDevices(devices *object.VirtualDeviceList, hardDisks []hardDisk) (map[string]types.BaseVirtualController, error) {
+	controllers := make(map[string]types.BaseVirtualController)
+
+	for _, hd := range hardDisks {
+		controllerType := hd.controllerType
+		if controllerType == "" {
+			controllerType = "scsi"
+		}
+		if _, ok := controllers[controllerType]; ok {
+			continue
+		}
+
+		var device types.BaseVirtualDevice
+		var err error
+
+		if controllerType == "ide" {
+			device, err = devices.CreateIDEController()
+		} else {
+			device, err = devices.CreateSCSIController(scsiControllerKind(controllerType))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error creating %s controller: %s", controllerType, err)
+		}
+
+		*devices = append(*devices, device)
+		controllers[controllerType] = device.(types.BaseVirtualController)
+	}
+
+	return controllers, nil
+}
+
+func addNetworkDevices(finder *find.Finder, devices *object.VirtualDeviceList, networkInterfaces []networkInterface) error {
+	for _, network := range networkInterfaces {
+		netObj, err := finder.Network(context.TODO(), network.label)
+		if err != nil {
+			return err
+		}
+
+		backing, err := netObj.EthernetCardBackingInfo(context.TODO())
+		if err != nil {
+			return err
+		}
+
+		adapterType := network.adapterType
+		if adapterType == "" {
+			adapterType = "e1000"
+		}
+
+		nic, err := object.EthernetCardTypes().CreateEthernetCard(adapterType, backing)
+		if err != nil {
+			return err
+		}
+
+		if network.macAddress != "" {
+			card := nic.(types.BaseVirtualEthernetCard).GetVirtualEthernetCard()
+			card.AddressType = string(types.VirtualEthernetCardMacTypeManual)
+			card.MacAddress = network.macAddress
+		}
+
+		*devices = append(*devices, nic)
+	}
+
+	return nil
+}
+
+func addCdromDevices(devices *object.VirtualDeviceList, controllers map[string]types.BaseVirtualController, cdroms []cdrom) error {
+	if len(cdroms) == 0 {
+		return nil
+	}
+
+	controller, ok := controllers["ide"]
+	if !ok {
+		device, err := devices.CreateIDEController()
+		if err != nil {
+			return fmt.Errorf("error creating IDE controller for cdrom: %s", err)
+		}
+		*devices = append(*devices, device)
+		controller = device.(types.BaseVirtualController)
+		controllers["ide"] = controller
+	}
+
+	ide, ok := controller.(*types.VirtualIDEController)
+	if !ok {
+		return fmt.Errorf("cdrom requires an IDE controller")
+	}
+
+	for _, cd := range cdroms {
+		device, err := devices.CreateCdrom(ide)
+		if err != nil {
+			return fmt.Errorf("error creating cdrom device: %s", err)
+		}
+
+		device = devices.InsertIso(device, fmt.Sprintf("[%s] %s", cd.datastore, cd.path))
+		*devices = append(*devices, device)
+	}
+
+	return nil
+}
+
+func (vm *virtualMachine) createVirtualMachine(c *govmomi.Client) error {
+	dc, err := getDatacenter(c, vm.datacenter)
+	if err != nil {
+		return err
+	}
+
+	finder := find.NewFinder(c.Client, true).SetDatacenter(dc)
+
+	resourcePool, err := vm.getResourcePool(finder)
+	if err != nil {
+		return err
+	}
+
+	datastore, err := vm.getDatastore(finder)
+	if err != nil {
+		return err
+	}
+
+	folder, err := vm.resolveVMFolder(c, dc)
+	if err != nil {
+		return err
+	}
+
+	devices := object.VirtualDeviceList{}
+
+	controllers, err := addControllerDevices(&devices, vm.hardDisks)
+	if err != nil {
+		return err
+	}
+
+	var bootDiskKey int32
+	for _, hd := range vm.hardDisks {
+		controllerType := hd.controllerType
+		if controllerType == "" {
+			controllerType = "scsi"
+		}
+		controller := controllers[controllerType]
+
+		var datastorePath object.DatastorePath
+		if hd.vmdkPath != "" {
+			datastorePath.Path = hd.vmdkPath
+		}
+
+		disk := devices.CreateDisk(controller, datastorePath)
+		if backing, ok := disk.Backing.(*types.VirtualDiskFlatVer2BackingInfo); ok {
+			applyDiskProvisioning(backing, hd.diskType)
+		}
+
+		if hd.vmdkPath == "" {
+			disk.CapacityInKB = hd.size * 1024 * 1024
+			if hd.iops > 0 {
+				iops := hd.iops
+				disk.StorageIOAllocation = &types.StorageIOAllocationInfo{Limit: &iops}
+			}
+		}
+
+		if hd.bootable {
+			bootDiskKey = disk.Key
+		}
+
+		devices = append(devices, disk)
+	}
+
+	if err := addCdromDevices(&devices, controllers, vm.cdroms); err != nil {
+		return err
+	}
+
+	if err := addNetworkDevices(finder, &devices, vm.networkInterfaces); err != nil {
+		return err
+	}
+
+	deviceChange, err := devices.ConfigSpec(types.VirtualDeviceConfigSpecOperationAdd)
+	if err != nil {
+		return err
+	}
+
+	spec := types.VirtualMachineConfigSpec{
+		Name:        vm.name,
+		NumCPUs:     vm.vcpu,
+		MemoryMB:    vm.memoryMb,
+		ExtraConfig: extraConfig(vm.customConfigurationParameters),
+		Files: &types.VirtualMachineFileInfo{
+			VmPathName: fmt.Sprintf("[%s]", datastore.Name()),
+		},
+		DeviceChange: deviceChange,
+	}
+
+	if bootDiskKey != 0 {
+		spec.BootOptions = &types.VirtualMachineBootOptions{
+			BootOrder: []types.BaseVirtualMachineBootOptionsBootableDevice{
+				&types.VirtualMachineBootOptionsBootableDiskDevice{DeviceKey: bootDiskKey},
+			},
+		}
+	}
+
+	task, err := folder.CreateVM(context.TODO(), spec, resourcePool, nil)
+	if err != nil {
+		return err
+	}
+
+	return task.Wait(context.TODO())
+}
@@ -0,0 +1,234 @@
+package vsphere
+
+import (
+	"testing"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestIpv4MaskString(t *testing.T) {
+	cases := []struct {
+		prefixLength int
+		want         string
+	}{
+		{24, "255.255.255.0"},
+		{16, "255.255.0.0"},
+		{32, "255.255.255.255"},
+		{0, "0.0.0.0"},
+	}
+
+	for _, c := range cases {
+		if got := ipv4MaskString(c.prefixLength); got != c.want {
+			t.Errorf("ipv4MaskString(%d) = %q, want %q", c.prefixLength, got, c.want)
+		}
+	}
+}
+
+func TestScsiControllerKind(t *testing.T) {
+	cases := []struct {
+		controllerType string
+		want           string
+	}{
+		{"scsi", "lsilogic"},
+		{"scsi-lsi-parallel", "lsilogic"},
+		{"scsi-buslogic", "buslogic"},
+		{"scsi-paravirtual", "pvscsi"},
+		{"scsi-lsi-sas", "lsilogic-sas"},
+	}
+
+	for _, c := range cases {
+		if got := scsiControllerKind(c.controllerType); got != c.want {
+			t.Errorf("scsiControllerKind(%q) = %q, want %q", c.controllerType, got, c.want)
+		}
+	}
+}
+
+func TestIsWindowsGuestID(t *testing.T) {
+	cases := []struct {
+		guestID string
+		want    bool
+	}{
+		{"windows9Server64Guest", true},
+		{"windows7_64Guest", true},
+		{"rhel7_64Guest", false},
+		{"ubuntu64Guest", false},
+	}
+
+	for _, c := range cases {
+		if got := isWindowsGuestID(c.guestID); got != c.want {
+			t.Errorf("isWindowsGuestID(%q) = %v, want %v", c.guestID, got, c.want)
+		}
+	}
+}
+
+func TestExtraConfig(t *testing.T) {
+	// a key present with an empty value (the unset convention used by
+	// resourceVSphereVirtualMachineUpdate for dropped keys) must still be
+	// emitted, not skipped.
+	params := map[string]string{
+		"guestinfo.foo": "bar",
+		"guestinfo.baz": "",
+	}
+
+	options := extraConfig(params)
+	if len(options) != len(params) {
+		t.Fatalf("extraConfig returned %d options, want %d", len(options), len(params))
+	}
+
+	got := make(map[string]string, len(options))
+	for _, opt := range options {
+		ov := opt.(*types.OptionValue)
+		got[ov.Key] = ov.Value.(string)
+	}
+
+	for k, v := range params {
+		if got[k] != v {
+			t.Errorf("extraConfig()[%q] = %q, want %q", k, got[k], v)
+		}
+	}
+
+	if extraConfig(nil) != nil {
+		t.Errorf("extraConfig(nil) = non-nil, want nil")
+	}
+}
+
+func diskTestResourceData(t *testing.T, raw map[string]interface{}) *schema.ResourceData {
+	return schema.TestResourceDataRaw(t, resourceVSphereVirtualMachine().Schema, raw)
+}
+
+func TestDiskDeviceChangesGrowsExistingDisk(t *testing.T) {
+	d := diskTestResourceData(t, map[string]interface{}{
+		"disk": []interface{}{
+			map[string]interface{}{"size": 20},
+		},
+	})
+
+	devices := object.VirtualDeviceList{}
+	controller, err := devices.CreateSCSIController("lsilogic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	devices = append(devices, controller)
+
+	disk := devices.CreateDisk(controller.(types.BaseVirtualController), object.DatastorePath{})
+	disk.CapacityInKB = 10 * 1024 * 1024
+	devices = append(devices, disk)
+
+	changes, bootDiskKey, err := diskDeviceChanges(d, devices)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bootDiskKey != 0 {
+		t.Errorf("bootDiskKey = %d, want 0", bootDiskKey)
+	}
+	if len(changes) != 1 {
+		t.Fatalf("len(changes) = %d, want 1", len(changes))
+	}
+
+	spec := changes[0].(*types.VirtualDeviceConfigSpec)
+	if spec.Operation != types.VirtualDeviceConfigSpecOperationEdit {
+		t.Errorf("Operation = %v, want Edit", spec.Operation)
+	}
+	grown := spec.Device.(*types.VirtualDisk)
+	if grown.CapacityInKB != 20*1024*1024 {
+		t.Errorf("CapacityInKB = %d, want %d", grown.CapacityInKB, 20*1024*1024)
+	}
+}
+
+func TestDiskDeviceChangesAttachesExistingVmdk(t *testing.T) {
+	d := diskTestResourceData(t, map[string]interface{}{
+		"disk": []interface{}{
+			map[string]interface{}{"controller_type": "ide", "vmdk": "disks/existing.vmdk"},
+		},
+	})
+
+	changes, _, err := diskDeviceChanges(d, object.VirtualDeviceList{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// one change to add the IDE controller, one to attach the disk.
+	if len(changes) != 2 {
+		t.Fatalf("len(changes) = %d, want 2", len(changes))
+	}
+
+	spec := changes[1].(*types.VirtualDeviceConfigSpec)
+	if spec.FileOperation == types.VirtualDeviceConfigSpecFileOperationCreate {
+		t.Errorf("FileOperation = create, want empty (attach, not create) for a disk with vmdk set")
+	}
+	disk := spec.Device.(*types.VirtualDisk)
+	backing := disk.Backing.(*types.VirtualDiskFlatVer2BackingInfo)
+	if backing.FileName != "disks/existing.vmdk" {
+		t.Errorf("FileName = %q, want %q", backing.FileName, "disks/existing.vmdk")
+	}
+}
+
+func TestCdromDeviceChangesReplacesIso(t *testing.T) {
+	d := diskTestResourceData(t, map[string]interface{}{
+		"cdrom": []interface{}{
+			map[string]interface{}{"datastore": "datastore1", "path": "iso/new.iso"},
+		},
+	})
+
+	devices := object.VirtualDeviceList{}
+	ide, err := devices.CreateIDEController()
+	if err != nil {
+		t.Fatal(err)
+	}
+	devices = append(devices, ide)
+
+	existing, err := devices.CreateCdrom(ide.(*types.VirtualIDEController))
+	if err != nil {
+		t.Fatal(err)
+	}
+	existing = devices.InsertIso(existing, "[datastore1] iso/old.iso")
+	devices = append(devices, existing)
+
+	changes, err := cdromDeviceChanges(d, devices)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 {
+		t.Fatalf("len(changes) = %d, want 1", len(changes))
+	}
+
+	spec := changes[0].(*types.VirtualDeviceConfigSpec)
+	if spec.Operation != types.VirtualDeviceConfigSpecOperationEdit {
+		t.Errorf("Operation = %v, want Edit", spec.Operation)
+	}
+	backing := spec.Device.(*types.VirtualCdrom).Backing.(*types.VirtualCdromIsoBackingInfo)
+	if backing.FileName != "[datastore1] iso/new.iso" {
+		t.Errorf("FileName = %q, want %q", backing.FileName, "[datastore1] iso/new.iso")
+	}
+}
+
+func TestCdromDeviceChangesRemovesDroppedBlocks(t *testing.T) {
+	d := diskTestResourceData(t, map[string]interface{}{})
+
+	devices := object.VirtualDeviceList{}
+	ide, err := devices.CreateIDEController()
+	if err != nil {
+		t.Fatal(err)
+	}
+	devices = append(devices, ide)
+
+	existing, err := devices.CreateCdrom(ide.(*types.VirtualIDEController))
+	if err != nil {
+		t.Fatal(err)
+	}
+	devices = append(devices, existing)
+
+	changes, err := cdromDeviceChanges(d, devices)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 {
+		t.Fatalf("len(changes) = %d, want 1", len(changes))
+	}
+	spec := changes[0].(*types.VirtualDeviceConfigSpec)
+	if spec.Operation != types.VirtualDeviceConfigSpecOperationRemove {
+		t.Errorf("Operation = %v, want Remove", spec.Operation)
+	}
+}